@@ -0,0 +1,193 @@
+package vfscache
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// blobsDirName is the subdirectory of the cache root holding the
+// content-addressed blob store
+const blobsDirName = ".blobs"
+
+// blobRefsFileName persists blob refcounts across restarts
+const blobRefsFileName = "refs.json"
+
+// blobStore is a content-addressed store of cache file bodies, keyed
+// by the hash of the remote object they came from. Cache files with
+// identical content across different paths share a single blob on
+// disk via a hardlink (or reflink where supported), saving both
+// space and the bandwidth needed to re-download duplicates.
+type blobStore struct {
+	mu       sync.Mutex
+	dir      string // c.root/.blobs
+	refsPath string
+	refs     map[string]int // hash -> number of cache files linked to it
+}
+
+// newBlobStore creates a blobStore rooted at cacheRoot/.blobs
+func newBlobStore(cacheRoot string) *blobStore {
+	dir := filepath.Join(cacheRoot, blobsDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fs.Errorf(nil, "vfs cache: failed to create blob store: %v", err)
+	}
+	b := &blobStore{
+		dir:      dir,
+		refsPath: filepath.Join(dir, blobRefsFileName),
+		refs:     make(map[string]int),
+	}
+	b.load()
+	return b
+}
+
+// path returns the on disk path for hash, sharded by its first two
+// characters to avoid huge flat directories
+func (b *blobStore) path(hashValue string) string {
+	prefix := hashValue
+	if len(prefix) > 2 {
+		prefix = prefix[:2]
+	}
+	return filepath.Join(b.dir, prefix, hashValue)
+}
+
+// load reads persisted refcounts, best effort
+func (b *blobStore) load() {
+	data, err := ioutil.ReadFile(b.refsPath)
+	if err != nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = json.Unmarshal(data, &b.refs)
+}
+
+// save persists refcounts, best effort
+//
+// must be called with b.mu held
+func (b *blobStore) _save() {
+	data, err := json.Marshal(b.refs)
+	if err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(b.refsPath, data, 0600)
+}
+
+// Has returns whether hashValue already has a blob on disk
+func (b *blobStore) Has(hashValue string) bool {
+	if hashValue == "" {
+		return false
+	}
+	_, err := os.Stat(b.path(hashValue))
+	return err == nil
+}
+
+// LinkInto links the blob for hashValue into dest, incrementing its
+// refcount. It is an error to call this unless Has(hashValue) is true.
+func (b *blobStore) LinkInto(hashValue, dest string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src := b.path(hashValue)
+	if err := linkOrCopy(src, dest); err != nil {
+		return err
+	}
+	b.refs[hashValue]++
+	b._save()
+	return nil
+}
+
+// Commit adopts the completed cache file at srcPath as the blob for
+// hashValue, linking srcPath to the blob location. If a blob for
+// hashValue already exists this is a no-op (the caller keeps using
+// its own copy at srcPath).
+func (b *blobStore) Commit(hashValue, srcPath string) error {
+	if hashValue == "" {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dest := b.path(hashValue)
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	if err := linkOrCopy(srcPath, dest); err != nil {
+		return err
+	}
+	b.refs[hashValue] = 1
+	b._save()
+	return nil
+}
+
+// RefCount returns the current number of cache files linked to
+// hashValue's blob
+func (b *blobStore) RefCount(hashValue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.refs[hashValue]
+}
+
+// Release drops one reference to hashValue's blob, removing it from
+// disk once the refcount reaches zero
+func (b *blobStore) Release(hashValue string) {
+	if hashValue == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count, ok := b.refs[hashValue]
+	if !ok {
+		return
+	}
+	count--
+	if count <= 0 {
+		delete(b.refs, hashValue)
+		if err := os.Remove(b.path(hashValue)); err != nil && !os.IsNotExist(err) {
+			fs.Errorf(nil, "vfs cache: failed to remove blob %s: %v", hashValue, err)
+		}
+	} else {
+		b.refs[hashValue] = count
+	}
+	b._save()
+}
+
+// linkOrCopy makes dest a copy of src as cheaply as the filesystem
+// allows: a reflink if supported, falling back to a hardlink, falling
+// back to a plain byte copy (e.g. Windows without link privileges, or
+// src/dest on different filesystems).
+func linkOrCopy(src, dest string) error {
+	if err := reflink(src, dest); err == nil {
+		return nil
+	}
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	return copyFile(src, dest)
+}
+
+// copyFile does a plain byte-for-byte copy of src to dest
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
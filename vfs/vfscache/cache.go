@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -37,6 +37,12 @@ type Cache struct {
 	used       int64              // total size of files in the cache
 	hashType   hash.Type          // hash to use locally and remotely
 	hashOption *fs.HashesOption   // corresponding OpenOption
+	hits       *hitTracker        // tracks per-path access counts for CacheMinHits gating
+	policy     EvictionPolicy     // decides what purgeOverQuota removes first
+	excludeRe  []*regexp.Regexp   // compiled CacheExclude patterns
+	includeRe  []*regexp.Regexp   // compiled CacheInclude patterns
+	blobs      *blobStore         // content-addressed store for deduplicating cache file bodies
+	stats      stats              // hit/miss/eviction/bytes counters, see Stats()
 }
 
 // New creates a new cache heirachy for fremote
@@ -77,6 +83,11 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options) (*Cache, er
 		item:       make(map[string]*Item),
 		hashType:   hashType,
 		hashOption: hashOption,
+		hits:       newHitTracker(metaRoot),
+		policy:     newEvictionPolicy(opt.CacheEvictionPolicy, metaRoot),
+		excludeRe:  compileGlobs(opt.CacheExclude),
+		includeRe:  compileGlobs(opt.CacheInclude),
+		blobs:      newBlobStore(root),
 	}
 
 	// Make sure cache directories exist
@@ -120,6 +131,33 @@ func (c *Cache) objectFingerprint(o fs.Object) string {
 	return out.String()
 }
 
+// recordHit notes an access to name and returns the number of hits
+// seen within the current CacheHitWindow
+func (c *Cache) recordHit(name string) int {
+	return c.hits.hit(name, c.opt.CacheHitWindow)
+}
+
+// shouldMaterialize returns true if name has been hit enough times
+// to be worth writing into the on-disk cache. When CacheMinHits is
+// not set every access materializes the file, preserving the
+// existing behaviour.
+func (c *Cache) shouldMaterialize(name string) bool {
+	if c.opt.CacheMinHits <= 0 {
+		return true
+	}
+	return c.hits.count(name) >= c.opt.CacheMinHits
+}
+
+// ShouldCache returns whether name is allowed to be materialized in
+// the local cache. CacheInclude patterns always win, so they can be
+// used to carve out exceptions to a broader CacheExclude pattern.
+func (c *Cache) ShouldCache(name string) bool {
+	if matchAny(c.excludeRe, name) && !matchAny(c.includeRe, name) {
+		return false
+	}
+	return true
+}
+
 // clean returns the cleaned version of name for use in the index map
 func clean(name string) string {
 	name = strings.Trim(name, "/")
@@ -164,15 +202,25 @@ func (c *Cache) mkdir(name string) (string, error) {
 // It returns the item and found as to whether this item was found in
 // the cache (or just created).
 //
+// If name is excluded from caching by CacheExclude/CacheInclude and
+// wasn't already cached, item is nil - callers must stream straight
+// from fremote in that case rather than touching c.root/c.metaRoot.
+//
 // name should be a remote path not an osPath
 //
 // must be called with itemMu held
 func (c *Cache) _get(name string) (item *Item, found bool) {
 	item = c.item[name]
 	found = item != nil
-	if !found {
+	if found {
+		c.stats.hit()
+	} else {
+		if !c.ShouldCache(name) {
+			return nil, false
+		}
 		item = newItem(c, name)
 		c.item[name] = item
+		c.stats.miss()
 	}
 	return item, found
 }
@@ -231,6 +279,10 @@ func (c *Cache) get(name string) (item *Item, found bool) {
 //
 // To use it item.Open will need to be called
 //
+// Item returns nil if name is excluded from caching by
+// CacheExclude/CacheInclude - callers must stream the read directly
+// from the remote object in that case.
+//
 // name should be a remote path not an osPath
 func (c *Cache) Item(name string) (item *Item) {
 	item, _ = c.get(name)
@@ -294,6 +346,11 @@ func rename(osOldPath, osNewPath string) error {
 // Rename the item in cache
 func (c *Cache) Rename(name string, newName string, newObj fs.Object) (err error) {
 	item, _ := c.get(name)
+	if item == nil {
+		// name was never cached (excluded by CacheExclude) - nothing
+		// on disk to rename
+		return nil
+	}
 	err = item.rename(name, newName, newObj)
 	if err != nil {
 		return err
@@ -314,12 +371,18 @@ func (c *Cache) Rename(name string, newName string, newObj fs.Object) (err error
 // Remove should be called if name is deleted
 func (c *Cache) Remove(name string) {
 	item, _ := c.get(name)
-	item.remove("file deleted")
+	if item != nil {
+		item.remove("file deleted")
+	}
+	c.hits.remove(name)
 }
 
 // SetModTime should be called to set the modification time of the cache file
 func (c *Cache) SetModTime(name string, modTime time.Time) {
 	item, _ := c.get(name)
+	if item == nil {
+		return
+	}
 	item.setModTime(modTime)
 }
 
@@ -355,10 +418,19 @@ func (c *Cache) walk(dir string, fn func(osPath string, fi os.FileInfo, name str
 }
 
 // reload walks the cache loading metadata files
+//
+// Items found on disk are registered with the eviction policy once, as
+// they are first loaded, so that files which survive a restart are
+// still eviction candidates even before they are next opened.
 func (c *Cache) reload() error {
 	err := c.walk(c.root, func(osPath string, fi os.FileInfo, name string) error {
+		if fi.IsDir() && fi.Name() == blobsDirName {
+			return filepath.SkipDir
+		}
 		if !fi.IsDir() {
-			_, _ = c.get(name)
+			if item, found := c.get(name); item != nil && !found {
+				c.policy.Touch(item)
+			}
 		}
 		return nil
 	})
@@ -366,8 +438,13 @@ func (c *Cache) reload() error {
 		return errors.Wrap(err, "failed to walk cache")
 	}
 	err = c.walk(c.root, func(osPathMeta string, fi os.FileInfo, name string) error {
+		if fi.IsDir() && fi.Name() == blobsDirName {
+			return filepath.SkipDir
+		}
 		if !fi.IsDir() {
-			_, _ = c.get(name)
+			if item, found := c.get(name); item != nil && !found {
+				c.policy.Touch(item)
+			}
 		}
 		return nil
 	})
@@ -382,10 +459,14 @@ func (c *Cache) purgeOld(maxAge time.Duration) {
 	c._purgeOld(maxAge, func(item *Item) {
 		// Note item.mu is held here
 		item._remove("too old")
+		c.stats.evictedTooOld()
 	})
 }
 
 func (c *Cache) _purgeOld(maxAge time.Duration, remove func(item *Item)) {
+	c.hits.purgeStale(c.opt.CacheHitWindow)
+	defer c.hits.persist()
+
 	c.itemMu.Lock()
 	defer c.itemMu.Unlock()
 	cutoff := time.Now().Add(-maxAge)
@@ -399,6 +480,11 @@ func (c *Cache) _purgeOld(maxAge time.Duration, remove func(item *Item)) {
 				remove(item)
 				// Remove the entry
 				delete(c.item, name)
+				item.mu.Unlock()
+				// Evicted must be called without item.mu held - some
+				// policies (eg lfu, arc) take it to read the name
+				c.policy.Evicted(item)
+				continue
 			}
 		}
 		item.mu.Unlock()
@@ -418,42 +504,36 @@ func (c *Cache) purgeEmptyDirs() {
 	}
 }
 
-type cacheItems []*Item
-
-func (v cacheItems) Len() int      { return len(v) }
-func (v cacheItems) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
-func (v cacheItems) Less(i, j int) bool {
-	if i == j {
-		return false
-	}
-	iItem := v[i]
-	jItem := v[j]
-	iItem.mu.Lock()
-	defer iItem.mu.Unlock()
-	jItem.mu.Lock()
-	defer jItem.mu.Unlock()
-
-	return iItem.ATime.Before(jItem.ATime)
-}
-
 // Remove any files that are over quota starting from the
 // oldest first
 func (c *Cache) purgeOverQuota(quota int64) {
 	c._purgeOverQuota(quota, func(item *Item) {
 		// Note item.mu is held here
 		item._remove("over quota")
+		c.stats.evictedOverQuota()
 	})
 }
 
 // updateUsed updates c.used so it is accurate
+//
+// Items sharing a blob via deduplication only count their size once,
+// since they occupy a single set of disk blocks between them.
 func (c *Cache) updateUsed() {
 	c.itemMu.Lock()
 	defer c.itemMu.Unlock()
 
 	newUsed := int64(0)
+	seenBlobs := make(map[string]bool)
 	for _, item := range c.item {
 		item.mu.Lock()
-		newUsed += item.Size // FIXME make this size on disk
+		if item.blobLinked {
+			if !seenBlobs[item.hash] {
+				seenBlobs[item.hash] = true
+				newUsed += item.Size // FIXME make this size on disk
+			}
+		} else {
+			newUsed += item.Size // FIXME make this size on disk
+		}
 		item.mu.Unlock()
 
 	}
@@ -461,6 +541,9 @@ func (c *Cache) updateUsed() {
 }
 
 func (c *Cache) _purgeOverQuota(quota int64, remove func(item *Item)) {
+	c.hits.purgeStale(c.opt.CacheHitWindow)
+	defer c.hits.persist()
+
 	c.updateUsed()
 
 	c.itemMu.Lock()
@@ -470,30 +553,30 @@ func (c *Cache) _purgeOverQuota(quota int64, remove func(item *Item)) {
 		return
 	}
 
-	var items cacheItems
+	// Ask the eviction policy for candidates, best one to remove first
+	candidates := c.policy.Candidates()
 
-	// Make a slice of unused files
-	for _, item := range c.item {
-		item.mu.Lock()
-		if item.opens == 0 {
-			items = append(items, item)
-		}
-		item.mu.Unlock()
-	}
-
-	sort.Sort(items)
-
-	// Remove items until the quota is OK
-	for _, item := range items {
+	// Remove items until the quota is OK, skipping any that are open
+	for _, item := range candidates {
 		if c.used < quota {
 			break
 		}
 		item.mu.Lock()
-		c.used -= item.Size // FIXME size on disk
+		if item.opens != 0 {
+			item.mu.Unlock()
+			continue
+		}
+		name := item.name
+		// Only free disk space once the last reference to a shared
+		// blob goes away
+		if !item.blobLinked || c.blobs.RefCount(item.hash) <= 1 {
+			c.used -= item.Size // FIXME size on disk
+		}
 		remove(item)
-		// Remove the entry
-		delete(c.item, item.name)
 		item.mu.Unlock()
+		// Remove the entry
+		delete(c.item, name)
+		c.policy.Evicted(item)
 	}
 }
 
@@ -516,6 +599,12 @@ func (c *Cache) clean() {
 	// oldest first
 	c.purgeOverQuota(int64(c.opt.CacheMaxSize))
 
+	// Flush any eviction policy state (eg lfu counts) that only gets
+	// marked dirty on access rather than saved immediately
+	if pp, ok := c.policy.(persistentPolicy); ok {
+		pp.persist()
+	}
+
 	// Stats
 	c.itemMu.Lock()
 	newItems, newUsed := len(c.item), fs.SizeSuffix(c.used)
@@ -562,6 +651,10 @@ func copyObj(f fs.Fs, dst fs.Object, remote string, src fs.Object) (newDst fs.Ob
 func (c *Cache) Check(ctx context.Context, o fs.Object, remote string) (err error) {
 	defer log.Trace(o, "remote=%q", remote)("err=%v", &err)
 	item, _ := c.get(remote)
+	if item == nil {
+		// remote is excluded from the cache entirely
+		return nil
+	}
 	item.checkObject(o)
 	err = item.truncateToCurrentSize()
 	if err != nil {
@@ -0,0 +1,637 @@
+package vfscache
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy decides which Items purgeOverQuota should remove
+// first. Implementations are responsible for their own bookkeeping -
+// Touch is called on every access (open or read) and Evicted once an
+// item has actually been removed from the cache.
+type EvictionPolicy interface {
+	// Touch records an access to item
+	Touch(item *Item)
+	// Candidates returns known items ordered from best to worst
+	// eviction candidate. Callers are responsible for skipping items
+	// that are still open.
+	Candidates() []*Item
+	// Evicted is called once item has been removed from the cache
+	Evicted(item *Item)
+}
+
+// newEvictionPolicy creates the EvictionPolicy named by policy,
+// persisting its state under metaRoot. Unknown names fall back to
+// "lru".
+func newEvictionPolicy(policy string, metaRoot string) EvictionPolicy {
+	switch policy {
+	case "lfu":
+		return newLFUPolicy(metaRoot)
+	case "arc":
+		return newARCPolicy(metaRoot)
+	case "size-weighted":
+		return newSizeWeightedPolicy(metaRoot)
+	default:
+		return newLRUPolicy(metaRoot)
+	}
+}
+
+// persistentPolicy is implemented by EvictionPolicy implementations
+// that batch their disk writes rather than saving on every Touch. The
+// cache cleaner calls persist periodically so that state is still
+// flushed even on an idle mount.
+type persistentPolicy interface {
+	persist()
+}
+
+// PolicyStats holds internal counters from the active EvictionPolicy.
+// Which fields are meaningful depends on CacheEvictionPolicy - see the
+// comment on each field.
+type PolicyStats struct {
+	TrackedItems int   // lru, lfu, size-weighted, arc: number of items known to the policy
+	TotalHits    int64 // lfu: sum of the hit counts of all tracked items
+	ARCTargetT1  int   // arc: current target size of T1, adapted from ghost list hits
+	ARCT1Items   int   // arc: items seen once recently
+	ARCT2Items   int   // arc: items seen more than once recently
+	ARCB1Items   int   // arc: ghost entries evicted from T1
+	ARCB2Items   int   // arc: ghost entries evicted from T2
+}
+
+// statsPolicy is implemented by EvictionPolicy implementations that
+// can report PolicyStats. Policies with nothing interesting to report
+// beyond TrackedItems can skip it - Cache.Stats falls back to zero
+// values.
+type statsPolicy interface {
+	Stats() PolicyStats
+}
+
+// itemName returns item.name taking item.mu
+func itemName(item *Item) string {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	return item.name
+}
+
+// -- LRU -------------------------------------------------------------
+
+// lruPolicy evicts the least recently touched item first - this is
+// the original cacheItems-by-ATime behaviour. The order of the list is
+// persisted so that items which aren't re-opened immediately after a
+// restart still sort correctly relative to ones that are.
+type lruPolicy struct {
+	mu       sync.Mutex
+	path     string
+	list     *list.List              // front = most recently used
+	elem     map[*Item]*list.Element // item -> its element in list
+	savedPos map[string]int          // order loaded from disk, name -> rank (0 = most recent), looked up as items are first touched and never pruned
+	dirty    bool
+}
+
+func newLRUPolicy(metaRoot string) *lruPolicy {
+	p := &lruPolicy{
+		path:     filepath.Join(metaRoot, "eviction-lru.json"),
+		list:     list.New(),
+		elem:     make(map[*Item]*list.Element),
+		savedPos: make(map[string]int),
+	}
+	if data, err := ioutil.ReadFile(p.path); err == nil {
+		var names []string
+		if json.Unmarshal(data, &names) == nil {
+			for i, name := range names {
+				p.savedPos[name] = i
+			}
+		}
+	}
+	return p
+}
+
+// Touch records a genuine access, always moving item to the front -
+// it is by definition the most recently used item now. An item seen
+// for the first time this run is instead inserted amongst the other
+// not-yet-re-touched items according to its saved position, if any.
+func (p *lruPolicy) Touch(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.elem[item]; ok {
+		p.list.MoveToFront(e)
+		return
+	}
+	p.elem[item] = p.insert(item)
+	p.dirty = true
+}
+
+// insert places a not-yet-seen item into the list, respecting its
+// saved position relative to other items that haven't been genuinely
+// re-touched yet
+//
+// must be called with p.mu held
+func (p *lruPolicy) insert(item *Item) *list.Element {
+	rank, ok := p.savedPos[itemName(item)]
+	if !ok {
+		return p.list.PushFront(item)
+	}
+	for e := p.list.Front(); e != nil; e = e.Next() {
+		if otherRank, ok := p.savedPos[itemName(e.Value.(*Item))]; ok && otherRank > rank {
+			return p.list.InsertBefore(item, e)
+		}
+	}
+	return p.list.PushBack(item)
+}
+
+// Stats implements statsPolicy
+func (p *lruPolicy) Stats() PolicyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PolicyStats{TrackedItems: p.list.Len()}
+}
+
+func (p *lruPolicy) Candidates() []*Item {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	items := make([]*Item, 0, p.list.Len())
+	for e := p.list.Back(); e != nil; e = e.Prev() {
+		items = append(items, e.Value.(*Item))
+	}
+	return items
+}
+
+func (p *lruPolicy) Evicted(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.elem[item]; ok {
+		p.list.Remove(e)
+		delete(p.elem, item)
+	}
+	p.dirty = true
+	p.save()
+}
+
+// persist writes the current order to disk if it has changed since
+// the last save. Call this periodically (eg from the cache cleaner)
+// rather than after every Touch.
+func (p *lruPolicy) persist() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.save()
+}
+
+// save persists the list order, most recently used first, if dirty
+//
+// must be called with p.mu held
+func (p *lruPolicy) save() {
+	if !p.dirty {
+		return
+	}
+	names := make([]string, 0, p.list.Len())
+	for e := p.list.Front(); e != nil; e = e.Next() {
+		names = append(names, itemName(e.Value.(*Item)))
+	}
+	data, err := json.Marshal(names)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(p.path, data, 0600); err != nil {
+		return
+	}
+	p.dirty = false
+}
+
+// -- LFU -------------------------------------------------------------
+
+// lfuPolicy evicts the item with the fewest recorded hits first,
+// persisting counts so restarts don't lose frequency information
+type lfuPolicy struct {
+	mu     sync.Mutex
+	path   string
+	counts map[*Item]int
+	saved  map[string]int // counts loaded from disk, keyed by name, looked up as items are first touched and never pruned
+	dirty  bool           // true if counts has changed since the last save
+}
+
+func newLFUPolicy(metaRoot string) *lfuPolicy {
+	p := &lfuPolicy{
+		path:   filepath.Join(metaRoot, "eviction-lfu.json"),
+		counts: make(map[*Item]int),
+		saved:  make(map[string]int),
+	}
+	if data, err := ioutil.ReadFile(p.path); err == nil {
+		_ = json.Unmarshal(data, &p.saved)
+	}
+	return p
+}
+
+func (p *lfuPolicy) Touch(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.counts[item]; !ok {
+		p.counts[item] = p.saved[itemName(item)]
+	}
+	p.counts[item]++
+	p.dirty = true
+}
+
+// Stats implements statsPolicy
+func (p *lfuPolicy) Stats() PolicyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var total int64
+	for _, count := range p.counts {
+		total += int64(count)
+	}
+	return PolicyStats{TrackedItems: len(p.counts), TotalHits: total}
+}
+
+func (p *lfuPolicy) Candidates() []*Item {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	items := make([]*Item, 0, len(p.counts))
+	for item := range p.counts {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return p.counts[items[i]] < p.counts[items[j]]
+	})
+	return items
+}
+
+func (p *lfuPolicy) Evicted(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.counts, item)
+	p.dirty = true
+	p.save()
+}
+
+// persist writes the counts to disk if they have changed since the
+// last save. Call this periodically (eg from the cache cleaner)
+// rather than after every Touch.
+func (p *lfuPolicy) persist() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.save()
+}
+
+// save persists the current counts keyed by name if dirty
+//
+// must be called with p.mu held
+func (p *lfuPolicy) save() {
+	if !p.dirty {
+		return
+	}
+	names := make(map[string]int, len(p.counts))
+	for item, count := range p.counts {
+		names[itemName(item)] = count
+	}
+	data, err := json.Marshal(names)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(p.path, data, 0600); err != nil {
+		return
+	}
+	p.dirty = false
+}
+
+// -- size-weighted -----------------------------------------------------
+
+// sizeWeightedPolicy scores items by atime_age * size, preferring to
+// evict large, old items over small, recently used ones. The ATime of
+// each item is persisted so that a restart doesn't make every cached
+// file look freshly accessed and so skew every score to zero.
+type sizeWeightedPolicy struct {
+	mu        sync.Mutex
+	path      string
+	items     map[*Item]struct{}
+	savedTime map[string]time.Time // ATime loaded from disk, looked up as items are first touched and never pruned
+	dirty     bool
+}
+
+func newSizeWeightedPolicy(metaRoot string) *sizeWeightedPolicy {
+	p := &sizeWeightedPolicy{
+		path:      filepath.Join(metaRoot, "eviction-size-weighted.json"),
+		items:     make(map[*Item]struct{}),
+		savedTime: make(map[string]time.Time),
+	}
+	if data, err := ioutil.ReadFile(p.path); err == nil {
+		_ = json.Unmarshal(data, &p.savedTime)
+	}
+	return p
+}
+
+func (p *sizeWeightedPolicy) Touch(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.items[item]; !ok {
+		if atime, ok := p.savedTime[itemName(item)]; ok {
+			restoreATime(item, atime)
+		}
+		p.items[item] = struct{}{}
+	}
+	p.dirty = true
+}
+
+// sizeWeightedScore returns the eviction score for item - higher sorts first
+func sizeWeightedScore(item *Item) float64 {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	age := time.Since(item.ATime)
+	if age < 0 {
+		age = 0
+	}
+	return float64(age) * float64(item.Size)
+}
+
+// restoreATime sets item.ATime to atime if it is older than the
+// item's current ATime, so a newly created Item (which defaults its
+// ATime to time.Now) doesn't look more recently used than it actually
+// was the last time the cache ran
+func restoreATime(item *Item, atime time.Time) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if atime.Before(item.ATime) {
+		item.ATime = atime
+	}
+}
+
+// Stats implements statsPolicy
+func (p *sizeWeightedPolicy) Stats() PolicyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PolicyStats{TrackedItems: len(p.items)}
+}
+
+func (p *sizeWeightedPolicy) Candidates() []*Item {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	items := make([]*Item, 0, len(p.items))
+	for item := range p.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return sizeWeightedScore(items[i]) > sizeWeightedScore(items[j])
+	})
+	return items
+}
+
+func (p *sizeWeightedPolicy) Evicted(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.items, item)
+	p.dirty = true
+	p.save()
+}
+
+// persist writes the known ATimes to disk if they have changed since
+// the last save. Call this periodically (eg from the cache cleaner)
+// rather than after every Touch.
+func (p *sizeWeightedPolicy) persist() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.save()
+}
+
+// save persists the current ATime of every known item, keyed by name,
+// if dirty
+//
+// must be called with p.mu held
+func (p *sizeWeightedPolicy) save() {
+	if !p.dirty {
+		return
+	}
+	times := make(map[string]time.Time, len(p.items))
+	for item := range p.items {
+		item.mu.Lock()
+		times[item.name] = item.ATime
+		item.mu.Unlock()
+	}
+	data, err := json.Marshal(times)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(p.path, data, 0600); err != nil {
+		return
+	}
+	p.dirty = false
+}
+
+// -- ARC -----------------------------------------------------------------
+
+// arcMaxGhostEntries bounds the length of each of B1/B2. Without a
+// cap, a long-running mount that keeps touching paths it never
+// revisits (eg thumbnailing or log tailing) would grow the ghost
+// lists without bound, since names are only ever removed from them by
+// being touched again.
+const arcMaxGhostEntries = 10000
+
+// arcPolicy is an adaptive replacement cache: it keeps two LRU lists
+// of real items - T1 (seen once recently) and T2 (seen more than
+// once) - plus two ghost lists of evicted names - B1 and B2 - used to
+// adapt the target size p of T1 based on which ghost list is hitting.
+type arcPolicy struct {
+	mu   sync.Mutex
+	path string
+
+	t1, t2, b1, b2 *list.List
+	t1elem, t2elem map[*Item]*list.Element
+	b1elem, b2elem map[string]*list.Element
+	p              int  // target size of t1
+	dirty          bool // true if b1/b2 or p has changed since the last save
+}
+
+func newARCPolicy(metaRoot string) *arcPolicy {
+	p := &arcPolicy{
+		path:   filepath.Join(metaRoot, "eviction-arc.json"),
+		t1:     list.New(),
+		t2:     list.New(),
+		b1:     list.New(),
+		b2:     list.New(),
+		t1elem: make(map[*Item]*list.Element),
+		t2elem: make(map[*Item]*list.Element),
+		b1elem: make(map[string]*list.Element),
+		b2elem: make(map[string]*list.Element),
+	}
+	if data, err := ioutil.ReadFile(p.path); err == nil {
+		var state struct {
+			B1 []string
+			B2 []string
+			P  int
+		}
+		if json.Unmarshal(data, &state) == nil {
+			for _, name := range state.B1 {
+				p.b1elem[name] = p.b1.PushBack(name)
+			}
+			for _, name := range state.B2 {
+				p.b2elem[name] = p.b2.PushBack(name)
+			}
+			trimGhostList(p.b1, p.b1elem, arcMaxGhostEntries)
+			trimGhostList(p.b2, p.b2elem, arcMaxGhostEntries)
+			p.p = state.P
+		}
+	}
+	return p
+}
+
+// Touch implements the ARC access algorithm from the original paper,
+// promoting hits in T1/T2 and adapting p on ghost hits in B1/B2.
+func (p *arcPolicy) Touch(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name := itemName(item)
+
+	if e, ok := p.t1elem[item]; ok {
+		p.t1.Remove(e)
+		delete(p.t1elem, item)
+		p.t2elem[item] = p.t2.PushFront(item)
+		return
+	}
+	if e, ok := p.t2elem[item]; ok {
+		p.t2.MoveToFront(e)
+		return
+	}
+	if e, ok := p.b1elem[name]; ok {
+		if p.b1.Len() > 0 {
+			delta := 1
+			if p.b2.Len() > p.b1.Len() {
+				delta = p.b2.Len() / p.b1.Len()
+			}
+			p.p += delta
+		}
+		p.b1.Remove(e)
+		delete(p.b1elem, name)
+		p.t2elem[item] = p.t2.PushFront(item)
+		p.dirty = true
+		return
+	}
+	if e, ok := p.b2elem[name]; ok {
+		if p.b2.Len() > 0 {
+			delta := 1
+			if p.b1.Len() > p.b2.Len() {
+				delta = p.b1.Len() / p.b2.Len()
+			}
+			if p.p > delta {
+				p.p -= delta
+			} else {
+				p.p = 0
+			}
+		}
+		p.b2.Remove(e)
+		delete(p.b2elem, name)
+		p.t2elem[item] = p.t2.PushFront(item)
+		p.dirty = true
+		return
+	}
+	p.t1elem[item] = p.t1.PushFront(item)
+}
+
+// trimGhostList removes entries from the back of l (the oldest
+// ghosts) until it is no longer over maxLen
+func trimGhostList(l *list.List, elem map[string]*list.Element, maxLen int) {
+	for l.Len() > maxLen {
+		back := l.Back()
+		if back == nil {
+			break
+		}
+		delete(elem, back.Value.(string))
+		l.Remove(back)
+	}
+}
+
+// Stats implements statsPolicy
+func (p *arcPolicy) Stats() PolicyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PolicyStats{
+		TrackedItems: p.t1.Len() + p.t2.Len(),
+		ARCTargetT1:  p.p,
+		ARCT1Items:   p.t1.Len(),
+		ARCT2Items:   p.t2.Len(),
+		ARCB1Items:   p.b1.Len(),
+		ARCB2Items:   p.b2.Len(),
+	}
+}
+
+// Candidates returns T1's LRU tail first if T1 is over its target
+// size p, otherwise T2's LRU tail first, followed by the rest of
+// both lists so a multi-item purge always has something to take.
+func (p *arcPolicy) Candidates() []*Item {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var items []*Item
+	fromT1 := p.t1.Len() > p.p
+	first, second := p.t2, p.t1
+	if fromT1 {
+		first, second = p.t1, p.t2
+	}
+	for e := first.Back(); e != nil; e = e.Prev() {
+		items = append(items, e.Value.(*Item))
+	}
+	for e := second.Back(); e != nil; e = e.Prev() {
+		items = append(items, e.Value.(*Item))
+	}
+	return items
+}
+
+// Evicted moves item into the appropriate ghost list, trimming it if
+// it has grown past arcMaxGhostEntries, and persists state
+func (p *arcPolicy) Evicted(item *Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name := itemName(item)
+	if e, ok := p.t1elem[item]; ok {
+		p.t1.Remove(e)
+		delete(p.t1elem, item)
+		p.b1elem[name] = p.b1.PushFront(name)
+		trimGhostList(p.b1, p.b1elem, arcMaxGhostEntries)
+	} else if e, ok := p.t2elem[item]; ok {
+		p.t2.Remove(e)
+		delete(p.t2elem, item)
+		p.b2elem[name] = p.b2.PushFront(name)
+		trimGhostList(p.b2, p.b2elem, arcMaxGhostEntries)
+	}
+	p.dirty = true
+	p.save()
+}
+
+// persist writes the ghost lists and p to disk if they have changed
+// since the last save. Call this periodically (eg from the cache
+// cleaner) to flush any state Touch marked dirty but which hasn't
+// been saved by an Evicted call yet.
+func (p *arcPolicy) persist() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.save()
+}
+
+// save persists the ghost lists and p so a restart can resume with
+// the same adaptive behaviour, if dirty
+//
+// must be called with p.mu held
+func (p *arcPolicy) save() {
+	if !p.dirty {
+		return
+	}
+	state := struct {
+		B1 []string
+		B2 []string
+		P  int
+	}{P: p.p}
+	for e := p.b1.Front(); e != nil; e = e.Next() {
+		state.B1 = append(state.B1, e.Value.(string))
+	}
+	for e := p.b2.Front(); e != nil; e = e.Next() {
+		state.B2 = append(state.B2, e.Value.(string))
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(p.path, data, 0600); err != nil {
+		return
+	}
+	p.dirty = false
+}
@@ -0,0 +1,79 @@
+package vfscache
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// globToRegexp compiles a glob pattern into an anchored regular
+// expression. "**" matches any number of path segments (including
+// none), a lone "*" matches within a single segment and "?" matches
+// a single character within a segment.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var out strings.Builder
+	out.WriteString("^")
+	rest := glob
+	for {
+		idx := strings.Index(rest, "**")
+		if idx < 0 {
+			out.WriteString(translateSegment(rest))
+			break
+		}
+		out.WriteString(translateSegment(rest[:idx]))
+		rest = rest[idx+2:]
+		// "**/" also matches zero directory segments, so a pattern
+		// like "**/*.iso" matches a root-level "foo.iso" too
+		if strings.HasPrefix(rest, "/") {
+			out.WriteString("(?:.*/)?")
+			rest = rest[1:]
+		} else {
+			out.WriteString(".*")
+		}
+	}
+	out.WriteString("$")
+	return regexp.Compile(out.String())
+}
+
+// translateSegment escapes regexp metacharacters in part while
+// turning "*" into "[^/]*" and "?" into "[^/]"
+func translateSegment(part string) string {
+	var out strings.Builder
+	for _, r := range part {
+		switch r {
+		case '*':
+			out.WriteString("[^/]*")
+		case '?':
+			out.WriteString("[^/]")
+		default:
+			out.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return out.String()
+}
+
+// compileGlobs compiles each pattern in patterns, logging and
+// skipping any that fail to compile
+func compileGlobs(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			fs.Errorf(nil, "vfs cache: ignoring invalid cache filter pattern %q: %v", pattern, err)
+			continue
+		}
+		res = append(res, re)
+	}
+	return res
+}
+
+// matchAny returns true if name matches any of res
+func matchAny(res []*regexp.Regexp, name string) bool {
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package vfscache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.iso", "foo.iso", true},
+		{"*.iso", "sub/foo.iso", false},
+		{"**/*.iso", "foo.iso", true},
+		{"**/*.iso", "a/b/c/foo.iso", true},
+		{"**/*.iso", "a/b/c/foo.txt", false},
+		{"archives/**", "archives/sub/dir/file.zip", true},
+		{"archives/**", "other/file.zip", false},
+		{"photo?.jpg", "photo1.jpg", true},
+		{"photo?.jpg", "photo12.jpg", false},
+	} {
+		re, err := globToRegexp(test.pattern)
+		require.NoError(t, err)
+		assert.Equal(t, test.want, re.MatchString(test.name), "pattern=%q name=%q", test.pattern, test.name)
+	}
+}
+
+func TestCacheShouldCache(t *testing.T) {
+	c := &Cache{
+		excludeRe: compileGlobs([]string{"archives/**", "*.iso"}),
+		includeRe: compileGlobs([]string{"archives/keep/**"}),
+	}
+
+	assert.False(t, c.ShouldCache("archives/big.zip"))
+	assert.False(t, c.ShouldCache("image.iso"))
+	assert.True(t, c.ShouldCache("archives/keep/readme.txt"), "include should override exclude")
+	assert.True(t, c.ShouldCache("thumbnails/a.jpg"))
+}
+
+func TestCacheGetExcluded(t *testing.T) {
+	c := &Cache{
+		item:      make(map[string]*Item),
+		excludeRe: compileGlobs([]string{"*.iso"}),
+	}
+
+	item, found := c.get("image.iso")
+	assert.Nil(t, item)
+	assert.False(t, found)
+	assert.Empty(t, c.item, "excluded paths must not be added to the item map")
+
+	item, found = c.get("readme.txt")
+	assert.NotNil(t, item)
+	assert.False(t, found)
+	assert.Len(t, c.item, 1)
+}
+
+func TestCacheRenameExcluded(t *testing.T) {
+	c := &Cache{
+		item:      make(map[string]*Item),
+		excludeRe: compileGlobs([]string{"*.iso"}),
+	}
+
+	err := c.Rename("image.iso", "renamed.iso", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, c.item, "renaming an excluded path must not create an entry")
+}
@@ -0,0 +1,147 @@
+package vfscache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// hitsFileName is the name of the file in the metadata root used to
+// persist hit counters across restarts
+const hitsFileName = "hit-counts.json"
+
+// hitCounter records how many times a path has been accessed within
+// the current window
+type hitCounter struct {
+	Count       int
+	WindowStart time.Time
+}
+
+// hitTracker tracks per-path access counts for the "cache after N
+// hits" gating feature. It is kept separate from the main item map
+// so it survives Item eviction.
+type hitTracker struct {
+	mu    sync.Mutex
+	path  string // path to the persisted hits file
+	hits  map[string]*hitCounter
+	dirty bool // true if hits has changed since the last persist
+}
+
+// newHitTracker creates a hitTracker persisting to metaRoot
+func newHitTracker(metaRoot string) *hitTracker {
+	t := &hitTracker{
+		path: filepath.Join(metaRoot, hitsFileName),
+		hits: make(map[string]*hitCounter),
+	}
+	t.load()
+	return t
+}
+
+// load reads the persisted hit counters from disk, ignoring errors
+// since this is best effort state
+func (t *hitTracker) load() {
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var hits map[string]*hitCounter
+	if err := json.Unmarshal(data, &hits); err != nil {
+		fs.Debugf(nil, "vfs cache: failed to parse hit counts: %v", err)
+		return
+	}
+	t.hits = hits
+}
+
+// save persists the hit counters to disk, logging but not failing on error
+//
+// must be called with t.mu held
+func (t *hitTracker) _save() {
+	data, err := json.Marshal(t.hits)
+	if err != nil {
+		fs.Errorf(nil, "vfs cache: failed to marshal hit counts: %v", err)
+		return
+	}
+	err = ioutil.WriteFile(t.path, data, 0600)
+	if err != nil {
+		fs.Errorf(nil, "vfs cache: failed to save hit counts: %v", err)
+	}
+}
+
+// hit records an access to name and returns the number of hits seen
+// within the current window
+//
+// This only marks the tracker dirty - persist() does the actual disk
+// write so a busy mount isn't doing a full marshal+write on every
+// single file open.
+func (t *hitTracker) hit(name string, window time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	counter := t.hits[name]
+	if counter == nil || (window > 0 && now.Sub(counter.WindowStart) > window) {
+		counter = &hitCounter{WindowStart: now}
+		t.hits[name] = counter
+	}
+	counter.Count++
+	t.dirty = true
+	return counter.Count
+}
+
+// persist writes the hit counters to disk if they have changed since
+// the last call. Callers should invoke this from a periodic point
+// such as the cache cleaner rather than after every hit.
+func (t *hitTracker) persist() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return
+	}
+	t._save()
+	t.dirty = false
+}
+
+// count returns the current number of hits recorded for name without
+// incrementing it
+func (t *hitTracker) count(name string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counter := t.hits[name]
+	if counter == nil {
+		return 0
+	}
+	return counter.Count
+}
+
+// purgeStale removes any counters whose window has expired, freeing
+// memory for paths that are no longer being accessed
+func (t *hitTracker) purgeStale(window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := time.Now().Add(-window)
+	for name, counter := range t.hits {
+		if counter.WindowStart.Before(cutoff) {
+			delete(t.hits, name)
+			t.dirty = true
+		}
+	}
+}
+
+// remove deletes the counter for name, eg when the file is removed
+// from the cache entirely
+func (t *hitTracker) remove(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.hits[name]; ok {
+		delete(t.hits, name)
+		t.dirty = true
+	}
+}
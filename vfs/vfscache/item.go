@@ -0,0 +1,303 @@
+package vfscache
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Item is stored in the item map
+//
+// The Item represents a file in the cache. It may or may not have
+// a local copy of the file on disk - see Present.
+type Item struct {
+	mu sync.Mutex // protect the variables
+
+	c    *Cache // cache this is part of
+	name string // remote name of the item
+
+	o fs.Object // object we are caching - may be nil
+
+	fd *os.File // handle to the underlying cache file if present
+
+	opens int       // number of times file is open
+	ATime time.Time // last time accessed
+	Size  int64     // size of the cache file, or of the remote object if not present
+
+	hash       string // content hash of o in c.hashType, used to dedupe against the blob store
+	blobLinked bool   // true if the cache file is a link into the blob store rather than its own copy
+
+	info info // persisted metadata about this item
+}
+
+// info is persisted alongside the cache file to allow Item state to
+// survive a restart
+type info struct {
+	Fingerprint string // stored object fingerprint at the time of writing
+	ModTime     time.Time
+}
+
+// newItem returns an item for the cache
+func newItem(c *Cache, name string) (item *Item) {
+	item = &Item{
+		c:     c,
+		name:  name,
+		ATime: time.Now(),
+	}
+	return item
+}
+
+// Present returns true if the whole file has been cached to disk
+func (item *Item) Present() bool {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	return item.c.Exists(item.name)
+}
+
+// checkObject updates the item's cached knowledge of the remote
+// object
+func (item *Item) checkObject(o fs.Object) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	item.o = o
+	if o != nil {
+		item.Size = o.Size()
+		item.info.Fingerprint = item.c.objectFingerprint(o)
+		item.info.ModTime = o.ModTime(context.Background())
+		item.hash = objectHash(item.c, o)
+	}
+}
+
+// objectHash returns o's hash in c.hashType, or "" if unavailable
+func objectHash(c *Cache, o fs.Object) string {
+	if c.hashType == hash.None {
+		return ""
+	}
+	h, err := o.Hash(context.Background(), c.hashType)
+	if err != nil {
+		return ""
+	}
+	return h
+}
+
+// breakLink makes the cache file independent of any blob it is
+// deduplicated against, so it is safe to mutate in place. On a
+// filesystem without reflink support, a deduplicated cache file is a
+// hardlink sharing the same inode as the blob (and every other cache
+// file linked to it) - truncating or chtiming that inode directly
+// would corrupt every other path sharing it. This is a no-op if the
+// item isn't blob-linked.
+//
+// must be called with item.mu held
+func (item *Item) breakLink() error {
+	if !item.blobLinked {
+		return nil
+	}
+	osPath := item.c.toOSPath(item.name)
+	tmpPath := osPath + ".unshare"
+	if err := copyFile(osPath, tmpPath); err != nil {
+		return errors.Wrap(err, "failed to copy-on-write shared cache file")
+	}
+	if err := os.Rename(tmpPath, osPath); err != nil {
+		return errors.Wrap(err, "failed to replace shared cache file")
+	}
+	item.c.blobs.Release(item.hash)
+	item.blobLinked = false
+	return nil
+}
+
+// truncateToCurrentSize truncates the cache file to item.Size if it
+// is present on disk
+func (item *Item) truncateToCurrentSize() error {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	osPath := item.c.toOSPath(item.name)
+	fi, err := os.Stat(osPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "truncate: failed to stat cache file")
+	}
+	if fi.Size() == item.Size {
+		return nil
+	}
+	if err := item.breakLink(); err != nil {
+		return errors.Wrap(err, "truncate: failed to unshare cache file")
+	}
+	err = os.Truncate(osPath, item.Size)
+	if err != nil {
+		return errors.Wrap(err, "truncate: failed to truncate cache file")
+	}
+	return nil
+}
+
+// setModTime sets the modification time of the cache file
+func (item *Item) setModTime(modTime time.Time) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	item.info.ModTime = modTime
+	if err := item.breakLink(); err != nil {
+		fs.Errorf(item.name, "Failed to unshare cached file before setting modification time: %v", err)
+		return
+	}
+	osPath := item.c.toOSPath(item.name)
+	err := os.Chtimes(osPath, modTime, modTime)
+	if err != nil && !os.IsNotExist(err) {
+		fs.Errorf(item.name, "Failed to set modification time of cached file: %v", err)
+	}
+}
+
+// rename the item and its metadata
+func (item *Item) rename(name string, newName string, newObj fs.Object) error {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	err := rename(item.c.toOSPath(name), item.c.toOSPath(newName))
+	if err != nil {
+		return err
+	}
+	err = rename(item.c.toOSPathMeta(name), item.c.toOSPathMeta(newName))
+	if err != nil {
+		return err
+	}
+	item.name = newName
+	item.o = newObj
+	return nil
+}
+
+// remove the cached file and its metadata, locking as necessary
+func (item *Item) remove(reason string) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	item._remove(reason)
+}
+
+// _remove removes the cached file and its metadata
+//
+// must be called with item.mu held
+func (item *Item) _remove(reason string) {
+	fs.Infof(item.name, "vfs cache: removing cached file (%s)", reason)
+	osPath := item.c.toOSPath(item.name)
+	err := os.Remove(osPath)
+	if err != nil && !os.IsNotExist(err) {
+		fs.Errorf(item.name, "Failed to remove cached file: %v", err)
+	}
+	osPathMeta := item.c.toOSPathMeta(item.name)
+	err = os.Remove(osPathMeta)
+	if err != nil && !os.IsNotExist(err) {
+		fs.Errorf(item.name, "Failed to remove cache metadata: %v", err)
+	}
+	if item.blobLinked {
+		item.c.blobs.Release(item.hash)
+		item.blobLinked = false
+	}
+}
+
+// Open the local file from the object passed in (which may be nil)
+// which implies we are about to create the file
+//
+// If the path hasn't been accessed CacheMinHits times within the
+// current CacheHitWindow then the cache file is not materialized and
+// reads are streamed straight from the remote instead - see ReadAt.
+func (item *Item) Open(o fs.Object) (err error) {
+	var hits int
+	if item.c.opt.CacheMinHits > 0 {
+		hits = item.c.recordHit(item.name)
+	}
+	item.c.policy.Touch(item)
+
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	item.opens++
+	item.ATime = time.Now()
+	if o != nil {
+		item.o = o
+		item.Size = o.Size()
+		item.hash = objectHash(item.c, o)
+	}
+	if item.fd == nil && item.c.shouldMaterialize(item.name) {
+		osPath, err := item.c.mkdir(item.name)
+		if err != nil {
+			return errors.Wrap(err, "vfs cache item: open mkdir failed")
+		}
+		if item.hash != "" && item.c.blobs.Has(item.hash) {
+			if linkErr := item.c.blobs.LinkInto(item.hash, osPath); linkErr == nil {
+				item.blobLinked = true
+				fs.Debugf(item.name, "vfs cache: deduplicated from existing blob %s", item.hash)
+			} else {
+				fs.Debugf(item.name, "vfs cache: failed to link blob, falling back to download: %v", linkErr)
+			}
+		}
+		fd, err := os.OpenFile(osPath, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return errors.Wrap(err, "vfs cache item: open failed")
+		}
+		item.fd = fd
+	} else if item.fd == nil {
+		fs.Debugf(item.name, "vfs cache: not materializing, only %d/%d hits", hits, item.c.opt.CacheMinHits)
+	}
+	return nil
+}
+
+// Close the cache file, committing it to the blob store for
+// deduplication if it is now complete and wasn't itself linked in
+// from an existing blob
+func (item *Item) Close() (err error) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	item.opens--
+	if item.opens < 0 {
+		panic("vfs cache item: double close")
+	}
+	if item.opens == 0 && item.fd != nil {
+		err = item.fd.Close()
+		item.fd = nil
+		if !item.blobLinked && item.hash != "" && !item.c.blobs.Has(item.hash) && item.c.Exists(item.name) {
+			osPath := item.c.toOSPath(item.name)
+			if fi, statErr := os.Stat(osPath); statErr == nil && fi.Size() == item.Size {
+				if commitErr := item.c.blobs.Commit(item.hash, osPath); commitErr != nil {
+					fs.Debugf(item.name, "vfs cache: failed to commit blob: %v", commitErr)
+				} else {
+					item.blobLinked = true
+				}
+			}
+		}
+	}
+	return err
+}
+
+// ReadAt reads from the cache file, falling back to the remote
+// object if the cache file isn't present
+func (item *Item) ReadAt(b []byte, off int64) (n int, err error) {
+	item.c.policy.Touch(item)
+
+	item.mu.Lock()
+	o := item.o
+	fd := item.fd
+	item.ATime = time.Now()
+	item.mu.Unlock()
+	if fd != nil {
+		n, err = fd.ReadAt(b, off)
+		item.c.stats.servedFromCache(n)
+		return n, err
+	}
+	if o == nil {
+		return 0, errors.New("vfs cache item: no object to read from")
+	}
+	in, err := o.Open(context.Background(), &fs.RangeOption{Start: off, End: off + int64(len(b)) - 1})
+	if err != nil {
+		return 0, errors.Wrap(err, "vfs cache item: open remote failed")
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	n, err = io.ReadFull(in, b)
+	item.c.stats.servedFromRemote(n)
+	return n, err
+}
@@ -0,0 +1,132 @@
+// Package metrics exports vfscache.Cache statistics as Prometheus
+// metrics
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rclone/rclone/vfs/vfscache"
+)
+
+// Collector is a prometheus.Collector reporting the counters from
+// cache.Stats(). Register it with a prometheus.Registerer to expose
+// vfs cache behaviour (hit rate, eviction reasons, used bytes, ...)
+// to operators tuning CacheMaxSize/CacheMaxAge/CacheEvictionPolicy.
+type Collector struct {
+	cache *vfscache.Cache
+
+	hits               *prometheus.Desc
+	misses             *prometheus.Desc
+	bytesFromCache     *prometheus.Desc
+	bytesFromRemote    *prometheus.Desc
+	evictionsTooOld    *prometheus.Desc
+	evictionsOverQuota *prometheus.Desc
+	usedBytes          *prometheus.Desc
+	items              *prometheus.Desc
+
+	// per-policy internals - see vfscache.PolicyStats
+	policyTrackedItems *prometheus.Desc
+	policyLFUTotalHits *prometheus.Desc
+	policyARCTargetT1  *prometheus.Desc
+	policyARCT1Items   *prometheus.Desc
+	policyARCT2Items   *prometheus.Desc
+	policyARCB1Items   *prometheus.Desc
+	policyARCB2Items   *prometheus.Desc
+}
+
+// New returns a Collector reporting the stats of cache
+func New(cache *vfscache.Cache) *Collector {
+	const namespace = "rclone"
+	const subsystem = "vfscache"
+	labels := []string{"eviction_policy"}
+	return &Collector{
+		cache: cache,
+		hits: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "hits_total"),
+			"Number of vfs cache accesses found already cached", labels, nil),
+		misses: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "misses_total"),
+			"Number of vfs cache accesses which created a new cache entry", labels, nil),
+		bytesFromCache: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "bytes_from_cache_total"),
+			"Bytes served from the local cache file", labels, nil),
+		bytesFromRemote: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "bytes_from_remote_total"),
+			"Bytes served by streaming straight from the remote", labels, nil),
+		evictionsTooOld: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "evictions_too_old_total"),
+			"Cache items removed for being over CacheMaxAge", labels, nil),
+		evictionsOverQuota: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "evictions_over_quota_total"),
+			"Cache items removed for being over CacheMaxSize", labels, nil),
+		usedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "used_bytes"),
+			"Current size of the vfs cache on disk", labels, nil),
+		items: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "items"),
+			"Current number of items in the vfs cache", labels, nil),
+		policyTrackedItems: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "policy_tracked_items"),
+			"Number of items known to the active eviction policy", labels, nil),
+		policyLFUTotalHits: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "policy_lfu_total_hits"),
+			"Sum of the hit counts of all items tracked by the lfu eviction policy", labels, nil),
+		policyARCTargetT1: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "policy_arc_target_t1"),
+			"Current target size of T1 in the arc eviction policy", labels, nil),
+		policyARCT1Items: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "policy_arc_t1_items"),
+			"Items in T1 (seen once recently) of the arc eviction policy", labels, nil),
+		policyARCT2Items: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "policy_arc_t2_items"),
+			"Items in T2 (seen more than once recently) of the arc eviction policy", labels, nil),
+		policyARCB1Items: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "policy_arc_b1_items"),
+			"Ghost entries evicted from T1 in the arc eviction policy", labels, nil),
+		policyARCB2Items: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "policy_arc_b2_items"),
+			"Ghost entries evicted from T2 in the arc eviction policy", labels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.bytesFromCache
+	ch <- c.bytesFromRemote
+	ch <- c.evictionsTooOld
+	ch <- c.evictionsOverQuota
+	ch <- c.usedBytes
+	ch <- c.items
+	ch <- c.policyTrackedItems
+	ch <- c.policyLFUTotalHits
+	ch <- c.policyARCTargetT1
+	ch <- c.policyARCT1Items
+	ch <- c.policyARCT2Items
+	ch <- c.policyARCB1Items
+	ch <- c.policyARCB2Items
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.cache.Stats()
+	label := stats.EvictionPolicy
+
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits), label)
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses), label)
+	ch <- prometheus.MustNewConstMetric(c.bytesFromCache, prometheus.CounterValue, float64(stats.BytesFromCache), label)
+	ch <- prometheus.MustNewConstMetric(c.bytesFromRemote, prometheus.CounterValue, float64(stats.BytesFromRemote), label)
+	ch <- prometheus.MustNewConstMetric(c.evictionsTooOld, prometheus.CounterValue, float64(stats.EvictionsTooOld), label)
+	ch <- prometheus.MustNewConstMetric(c.evictionsOverQuota, prometheus.CounterValue, float64(stats.EvictionsOverQuota), label)
+	ch <- prometheus.MustNewConstMetric(c.usedBytes, prometheus.GaugeValue, float64(stats.UsedBytes), label)
+	ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(stats.Items), label)
+
+	policy := stats.Policy
+	ch <- prometheus.MustNewConstMetric(c.policyTrackedItems, prometheus.GaugeValue, float64(policy.TrackedItems), label)
+	ch <- prometheus.MustNewConstMetric(c.policyLFUTotalHits, prometheus.GaugeValue, float64(policy.TotalHits), label)
+	ch <- prometheus.MustNewConstMetric(c.policyARCTargetT1, prometheus.GaugeValue, float64(policy.ARCTargetT1), label)
+	ch <- prometheus.MustNewConstMetric(c.policyARCT1Items, prometheus.GaugeValue, float64(policy.ARCT1Items), label)
+	ch <- prometheus.MustNewConstMetric(c.policyARCT2Items, prometheus.GaugeValue, float64(policy.ARCT2Items), label)
+	ch <- prometheus.MustNewConstMetric(c.policyARCB1Items, prometheus.GaugeValue, float64(policy.ARCB1Items), label)
+	ch <- prometheus.MustNewConstMetric(c.policyARCB2Items, prometheus.GaugeValue, float64(policy.ARCB2Items), label)
+}
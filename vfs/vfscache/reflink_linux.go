@@ -0,0 +1,40 @@
+// +build linux
+
+package vfscache
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ficloneConst mirrors linux/fs.h FICLONE, not exposed by x/sys/unix
+// on all architectures
+const ficloneConst = 0x40049409
+
+// reflink attempts a copy-on-write clone of src to dest via the
+// FICLONE ioctl, which is supported on btrfs, xfs and other
+// reflink-capable filesystems. It returns an error (and does nothing
+// to dest) if the filesystem doesn't support it.
+func reflink(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, out.Fd(), ficloneConst, in.Fd())
+	if errno != 0 {
+		_ = os.Remove(dest)
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package vfscache
+
+import "errors"
+
+// reflink is not implemented outside Linux - callers fall back to a
+// hardlink or plain copy
+func reflink(src, dest string) error {
+	return errors.New("reflink not supported on this platform")
+}
@@ -0,0 +1,61 @@
+package vfscache
+
+import "sync/atomic"
+
+// CacheStats is a snapshot of the counters tracked by Cache, useful
+// for operators tuning CacheMaxSize/CacheMaxAge/CacheEvictionPolicy
+// without having to parse debug logs.
+type CacheStats struct {
+	Hits               uint64      // number of Item accesses found already in the cache
+	Misses             uint64      // number of Item accesses which created a new entry
+	BytesFromCache     uint64      // bytes served from the local cache file
+	BytesFromRemote    uint64      // bytes served by streaming from fremote
+	EvictionsTooOld    uint64      // items removed by purgeOld
+	EvictionsOverQuota uint64      // items removed by purgeOverQuota
+	UsedBytes          int64       // current size of the cache on disk
+	Items              int         // current number of items in the cache
+	EvictionPolicy     string      // CacheEvictionPolicy as configured (unknown names run as "lru")
+	Policy             PolicyStats // internal counters from the active EvictionPolicy, see PolicyStats
+}
+
+// stats holds the live counters backing CacheStats - fields are only
+// ever accessed via the sync/atomic helpers below
+type stats struct {
+	hits               uint64
+	misses             uint64
+	bytesFromCache     uint64
+	bytesFromRemote    uint64
+	evictionsTooOld    uint64
+	evictionsOverQuota uint64
+}
+
+func (s *stats) hit()                   { atomic.AddUint64(&s.hits, 1) }
+func (s *stats) miss()                  { atomic.AddUint64(&s.misses, 1) }
+func (s *stats) servedFromCache(n int)  { atomic.AddUint64(&s.bytesFromCache, uint64(n)) }
+func (s *stats) servedFromRemote(n int) { atomic.AddUint64(&s.bytesFromRemote, uint64(n)) }
+func (s *stats) evictedTooOld()         { atomic.AddUint64(&s.evictionsTooOld, 1) }
+func (s *stats) evictedOverQuota()      { atomic.AddUint64(&s.evictionsOverQuota, 1) }
+
+// Stats returns a snapshot of the cache's current counters
+func (c *Cache) Stats() CacheStats {
+	c.itemMu.Lock()
+	items := len(c.item)
+	used := c.used
+	c.itemMu.Unlock()
+	var policyStats PolicyStats
+	if sp, ok := c.policy.(statsPolicy); ok {
+		policyStats = sp.Stats()
+	}
+	return CacheStats{
+		Hits:               atomic.LoadUint64(&c.stats.hits),
+		Misses:             atomic.LoadUint64(&c.stats.misses),
+		BytesFromCache:     atomic.LoadUint64(&c.stats.bytesFromCache),
+		BytesFromRemote:    atomic.LoadUint64(&c.stats.bytesFromRemote),
+		EvictionsTooOld:    atomic.LoadUint64(&c.stats.evictionsTooOld),
+		EvictionsOverQuota: atomic.LoadUint64(&c.stats.evictionsOverQuota),
+		UsedBytes:          used,
+		Items:              items,
+		EvictionPolicy:     c.opt.CacheEvictionPolicy,
+		Policy:             policyStats,
+	}
+}
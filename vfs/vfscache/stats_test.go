@@ -0,0 +1,22 @@
+package vfscache
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStatsHitsAndMisses(t *testing.T) {
+	c := &Cache{item: make(map[string]*Item), opt: &vfscommon.Options{}}
+
+	_, found := c.get("a")
+	assert.False(t, found)
+	_, found = c.get("a")
+	assert.True(t, found)
+
+	got := c.Stats()
+	assert.Equal(t, uint64(1), got.Misses)
+	assert.Equal(t, uint64(1), got.Hits)
+	assert.Equal(t, 1, got.Items)
+}
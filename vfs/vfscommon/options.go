@@ -0,0 +1,73 @@
+// Package vfscommon provides utilities/type for VFS and VFS Cache
+package vfscommon
+
+import (
+	"path"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// CacheMode controls the functionality of the cache
+type CacheMode byte
+
+// CacheMode options
+const (
+	CacheModeOff CacheMode = iota
+	CacheModeMinimal
+	CacheModeWrites
+	CacheModeFull
+)
+
+// Options is options for creating the cache
+type Options struct {
+	CacheMode         CacheMode
+	CacheMaxAge       time.Duration
+	CacheMaxSize      fs.SizeSuffix
+	CachePollInterval time.Duration
+	ChunkSize         fs.SizeSuffix
+	ChunkSizeLimit    fs.SizeSuffix
+	ReadAhead         fs.SizeSuffix
+	// CacheMinHits is the number of times a path must be opened within
+	// CacheHitWindow before it is materialized into the on-disk cache.
+	// Accesses below the threshold stream straight from the remote
+	// instead of writing a local cache file. 0 or negative disables
+	// gating (the default - everything is cached as before).
+	CacheMinHits int
+	// CacheHitWindow is the period over which hits towards
+	// CacheMinHits are counted. Once this period has passed since the
+	// first hit of the current window, the next hit starts a new count.
+	CacheHitWindow time.Duration
+	// CacheEvictionPolicy selects the strategy purgeOverQuota uses to
+	// pick which cached items to remove first. One of "lru" (the
+	// default), "lfu", "arc" or "size-weighted".
+	CacheEvictionPolicy string
+	// CacheExclude is a list of glob patterns (matched against the
+	// remote path, "**" matches any number of path segments) for
+	// files which should never be written to the local cache.
+	CacheExclude []string
+	// CacheInclude is a list of glob patterns which are always
+	// allowed to be cached even if they also match CacheExclude.
+	CacheInclude []string
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	CacheMode:           CacheModeOff,
+	CacheMaxAge:         3600 * time.Second,
+	CacheMaxSize:        -1,
+	CachePollInterval:   60 * time.Second,
+	ChunkSize:           128 * 1024 * 1024,
+	ChunkSizeLimit:      -1,
+	CacheEvictionPolicy: "lru",
+}
+
+// FindParent returns the parent directory of name, or "" if name is
+// already the root
+func FindParent(name string) string {
+	parent := path.Dir(name)
+	if parent == "." || parent == "/" {
+		parent = ""
+	}
+	return parent
+}